@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// resultRecord is the wire representation of a ScanResult used by the
+// json/ndjson output formats.
+type resultRecord struct {
+	Host         string   `json:"host"`
+	Port         uint64   `json:"port"`
+	State        string   `json:"state"`
+	RTTMillis    float64  `json:"rtt_ms,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	Timestamp    string   `json:"timestamp"`
+	Service      string   `json:"service,omitempty"`
+	Banner       string   `json:"banner,omitempty"`
+	TLSCN        string   `json:"tls_cn,omitempty"`
+	TLSSANs      []string `json:"tls_sans,omitempty"`
+	TLSALPN      string   `json:"tls_alpn,omitempty"`
+	ProxyWorking *bool    `json:"proxy_working,omitempty"`
+}
+
+func newResultRecord(res *ScanResult) resultRecord {
+	rec := resultRecord{
+		Host:      res.Host,
+		Port:      res.Port,
+		State:     res.State.String(),
+		RTTMillis: float64(res.RTT.Microseconds()) / 1000,
+		Timestamp: res.Timestamp.Format("2006-01-02T15:04:05.000Z07:00"),
+		Service:   res.Service,
+		Banner:    res.Banner,
+	}
+	if res.Err != nil {
+		rec.Error = res.Err.Error()
+	}
+	if res.TLSInfo != nil {
+		rec.TLSCN = res.TLSInfo.CommonName
+		rec.TLSSANs = res.TLSInfo.SANs
+		rec.TLSALPN = res.TLSInfo.ALPN
+	}
+	rec.ProxyWorking = res.ProxyWorking
+	return rec
+}
+
+// Writer is a sink for scan results, so callers can plug in custom
+// destinations (file, socket, etc) alongside the built-in encoders.
+type Writer interface {
+	Write(res *ScanResult) error
+	// Close flushes any buffered output, e.g. the closing bracket of a
+	// JSON array. Encoders that stream don't need to do anything here.
+	Close() error
+}
+
+// textWriter reproduces the historical "host:port: state" output.
+type textWriter struct {
+	w io.Writer
+}
+
+func newTextWriter(w io.Writer) *textWriter {
+	return &textWriter{w: w}
+}
+
+func (t *textWriter) Write(res *ScanResult) error {
+	if res.ProxyWorking != nil {
+		_, err := fmt.Fprintf(t.w, "%v:%v: %v (proxy_working=%v)\n", res.Host, res.Port, res.State, *res.ProxyWorking)
+		return err
+	}
+	if res.Service == "" {
+		_, err := fmt.Fprintf(t.w, "%v:%v: %v\n", res.Host, res.Port, res.State)
+		return err
+	}
+	_, err := fmt.Fprintf(t.w, "%v:%v: %v (%v)\n", res.Host, res.Port, res.State, res.Service)
+	return err
+}
+
+func (t *textWriter) Close() error { return nil }
+
+// ndjsonWriter emits one JSON object per result, newline-delimited, as
+// results arrive.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+func (n *ndjsonWriter) Write(res *ScanResult) error {
+	return n.enc.Encode(newResultRecord(res))
+}
+
+func (n *ndjsonWriter) Close() error { return nil }
+
+// jsonWriter buffers every record and emits a single JSON array on
+// Close, since a valid JSON array can't be streamed incrementally.
+type jsonWriter struct {
+	w       io.Writer
+	records []resultRecord
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	return &jsonWriter{w: w}
+}
+
+func (j *jsonWriter) Write(res *ScanResult) error {
+	j.records = append(j.records, newResultRecord(res))
+	return nil
+}
+
+func (j *jsonWriter) Close() error {
+	return json.NewEncoder(j.w).Encode(j.records)
+}
+
+// newWriter builds the Writer named by format.
+func newWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case "text":
+		return newTextWriter(w), nil
+	case "json":
+		return newJSONWriter(w), nil
+	case "ndjson":
+		return newNDJSONWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
@@ -1,18 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// Need worker pool because running 1 goroutine per port exhausts file descriptors
-const MAX_WORKERS = 100
-
 type PortRange struct {
 	Start uint64
 	End   uint64
@@ -24,30 +22,153 @@ func (pr *PortRange) String() string {
 
 // Run the port scanner
 func main() {
-	var host, port_range_arg string
-	var debug bool
+	var host, hostsArg, hostsFile, port_range_arg, portsFile, mode, iface, format, probesFile, socksCheckURL string
+	var debug, serviceID bool
+	var workers, retries, rateLimit int
+	var dialTimeout time.Duration
 	flag.StringVar(&host, "c", "", "host to scan")
+	flag.StringVar(&hostsArg, "hosts", "", "comma-separated hosts/CIDR blocks to scan")
+	flag.StringVar(&hostsFile, "hosts-file", "", "file of hosts/CIDR blocks to scan, one per line")
 	flag.StringVar(&port_range_arg, "r", "", "ports to scan")
+	flag.StringVar(&portsFile, "ports-file", "", "file of port ranges to scan, one per line")
+	flag.StringVar(&mode, "mode", "connect", "scan mode: connect|syn|socks5")
+	flag.StringVar(&iface, "iface", "", "network interface to use for -mode syn")
+	flag.StringVar(&format, "o", "text", "output format: text|json|ndjson")
 	flag.BoolVar(&debug, "debug", false, "include results on all ports")
+	flag.BoolVar(&serviceID, "sV", false, "probe open ports to identify the service")
+	flag.StringVar(&probesFile, "probes", "", "YAML/JSON file of probes overriding the built-in table")
+	flag.StringVar(&socksCheckURL, "socks-check-url", "", "URL to CONNECT through an open proxy for -mode socks5 to confirm it forwards traffic")
+	flag.IntVar(&workers, "workers", MAX_WORKERS, "number of concurrent workers for -mode connect")
+	flag.DurationVar(&dialTimeout, "dial-timeout", defaultDialTimeout, "per-port dial timeout for -mode connect")
+	flag.IntVar(&retries, "retries", 0, "dial retries for -mode connect before marking a port closed")
+	flag.IntVar(&rateLimit, "rate", 0, "max probes/sec for -mode connect (0 = unlimited)")
 	flag.Parse()
 
-	if host == "" || port_range_arg == "" {
-		fmt.Println("Usage: portscan -c <host> -range port|start-end, [port|start-end ...] [-debug]")
+	hostSpecs, err := gatherHostSpecs(host, hostsArg, hostsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	prs, err := gatherPortRanges(port_range_arg, portsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(hostSpecs) == 0 || len(prs) == 0 {
+		fmt.Println("Usage: portscan -c <host>|-hosts <h1,h2,...>|-hosts-file <path> -r port|start-end,...|-ports-file <path> [-mode connect|syn] [-o text|json|ndjson] [-debug]")
 		os.Exit(1)
 	}
-	prs, err := parseRanges(port_range_arg)
+
+	scanner, err := newScanner(mode, iface, workers, dialTimeout, retries, rateLimit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := newWriter(format, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	probes := defaultProbes()
+	if probesFile != "" {
+		probes, err = loadProbes(probesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	probesByPort, err := compileProbes(probes)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Format results
-	for _, pr := range prs {
-		results := ScanPorts(host, pr)
-		for port, success := range results {
-			if success || debug {
-				fmt.Printf("%v: %v\n", port, success)
+	ctx := context.Background()
+	gen := newTargetGenerator(hostSpecs, prs)
+
+	// Stream and format results as they arrive instead of waiting for
+	// the whole scan to finish.
+	for res := range scanner.ScanPorts(ctx, gen) {
+		if res.State != StateOpen && !debug {
+			continue
+		}
+		if res.State == StateOpen && serviceID {
+			res.Service, res.Banner, res.TLSInfo = identifyService(ctx, res.Host, res.Port, probesByPort)
+		}
+		if res.State == StateOpen && mode == "socks5" {
+			working, err := checkSocks5(ctx, res.Host, res.Port, socksCheckURL)
+			if err != nil {
+				res.Err = err
 			}
+			res.ProxyWorking = &working
+		}
+		if err := out.Write(res); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// gatherHostSpecs combines the -c, -hosts and -hosts-file flags into a
+// single list of host/CIDR specs.
+func gatherHostSpecs(host, hostsArg, hostsFile string) ([]string, error) {
+	var specs []string
+	if host != "" {
+		specs = append(specs, host)
+	}
+	specs = append(specs, parseHostSpecs(hostsArg)...)
+	if hostsFile != "" {
+		fromFile, err := readLines(hostsFile)
+		if err != nil {
+			return nil, err
 		}
+		specs = append(specs, fromFile...)
+	}
+	return specs, nil
+}
+
+// gatherPortRanges combines the -r and -ports-file flags into a single
+// list of port ranges.
+func gatherPortRanges(portRangeArg, portsFile string) ([]*PortRange, error) {
+	var ranges []*PortRange
+	if portRangeArg != "" {
+		prs, err := parseRanges(portRangeArg)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, prs...)
+	}
+	if portsFile != "" {
+		lines, err := readLines(portsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			pr, err := parseRange(line)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, pr)
+		}
+	}
+	return ranges, nil
+}
+
+// newScanner builds the scanner backend named by mode.
+func newScanner(mode, iface string, workers int, dialTimeout time.Duration, retries, rateLimit int) (interface {
+	ScanPorts(ctx context.Context, gen TargetGenerator) <-chan *ScanResult
+}, error) {
+	switch mode {
+	case "connect", "socks5":
+		// -mode socks5 still needs a real TCP connect to find open ports;
+		// the SOCKS5 greeting itself runs as a post-connect check in main.
+		return &ConnectScanner{
+			Workers:     workers,
+			DialTimeout: dialTimeout,
+			Retries:     retries,
+			RateLimit:   rateLimit,
+		}, nil
+	case "syn":
+		return &SynScanner{Iface: iface}, nil
+	default:
+		return nil, fmt.Errorf("unknown scan mode %q", mode)
 	}
 }
 
@@ -80,7 +201,7 @@ func parseRange(range_str string) (*PortRange, error) {
 	case 1:
 		return &PortRange{
 			Start: nums[0],
-			End:   nums[0] + 1,
+			End:   nums[0],
 		}, nil
 	case 2:
 		return &PortRange{
@@ -91,61 +212,3 @@ func parseRange(range_str string) (*PortRange, error) {
 		return nil, fmt.Errorf("Invalid Port Specification")
 	}
 }
-
-// Container for scan results from workers
-type ScanResult struct {
-	Port    uint64
-	Success bool
-	Err     error
-}
-
-// Run the scan with a worker pool; memory usage grows in proportion
-// with number of ports scanned to prevent deadlock from blocking channels
-func ScanPorts(host string, pr *PortRange) map[uint64]bool {
-	num_ports := pr.End - pr.Start + 1
-	results := make(map[uint64]bool)
-	jobpipe := make(chan uint64, num_ports)
-	respipe := make(chan *ScanResult, num_ports)
-
-	// Start workers
-	for worker := 0; worker < MAX_WORKERS; worker++ {
-		go scanWorker(host, jobpipe, respipe)
-	}
-
-	// Seed w/ jobs
-	for port := pr.Start; port < pr.End+1; port++ {
-		jobpipe <- port
-	}
-
-	// Receive results
-	received := uint64(0)
-	for received < pr.End-pr.Start {
-		res := <-respipe
-		results[res.Port] = res.Success
-		received += 1
-	}
-	return results
-}
-
-// Worker function; pull from job queue forever and return results on result
-// queue
-func scanWorker(host string, jobpipe chan uint64, respipe chan *ScanResult) {
-	for job := <-jobpipe; ; job = <-jobpipe {
-		respipe <- scanPort(host, job)
-	}
-}
-
-// Simple scan of a single port
-//	- Just tries to connect to <host>:<port> over TCP and checks for error
-func scanPort(host string, port uint64) *ScanResult {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%v:%v", host, port))
-	result := ScanResult{
-		Port:    port,
-		Success: err == nil,
-		Err:     err,
-	}
-	if conn != nil {
-		conn.Close()
-	}
-	return &result
-}
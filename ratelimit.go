@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// tokenBucket is a simple packets/sec limiter: a ticker refills a
+// buffered channel at the configured rate, and take() blocks until a
+// token is available (or the context is cancelled).
+type tokenBucket struct {
+	tokens chan struct{}
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+// maxBurst caps how many tokens a bucket can bank up, so an absurd
+// -rate doesn't try to allocate a multi-gigabyte channel.
+const maxBurst = 1 << 16
+
+// newTokenBucket starts a bucket that admits ratePerSec tokens/sec, with
+// room to burst up to min(ratePerSec, maxBurst) tokens before blocking.
+// ratePerSec is clamped to at least 1: time.NewTicker panics on a
+// non-positive duration, which time.Second/ratePerSec would produce for
+// any rate above 1e9.
+func newTokenBucket(ratePerSec int) *tokenBucket {
+	if ratePerSec < 1 {
+		ratePerSec = 1
+	}
+	interval := time.Second / time.Duration(ratePerSec)
+	if interval < time.Microsecond {
+		interval = time.Microsecond
+	}
+	burst := ratePerSec
+	if burst > maxBurst {
+		burst = maxBurst
+	}
+	b := &tokenBucket{
+		tokens: make(chan struct{}, burst),
+		ticker: time.NewTicker(interval),
+		done:   make(chan struct{}),
+	}
+	go b.refill()
+	return b
+}
+
+func (b *tokenBucket) refill() {
+	for {
+		select {
+		case <-b.ticker.C:
+			select {
+			case b.tokens <- struct{}{}:
+			default:
+				// bucket already full; drop this tick's token
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// take blocks until a token is available or ctx is cancelled.
+func (b *tokenBucket) take(ctx context.Context) error {
+	select {
+	case <-b.tokens:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *tokenBucket) close() {
+	b.ticker.Stop()
+	close(b.done)
+}
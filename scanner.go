@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Defaults used when a ConnectScanner field is left at its zero value.
+const (
+	MAX_WORKERS        = 100
+	defaultDialTimeout = 3 * time.Second
+)
+
+// PortState is the outcome of probing a single port. Unlike a plain
+// open/closed bool, a probe can also come back filtered (no response at
+// all within the timeout), which is indistinguishable from "closed" for a
+// dial-based scan but matters once we add raw SYN probing.
+type PortState int
+
+const (
+	StateUnknown PortState = iota
+	StateOpen
+	StateClosed
+	StateFiltered
+)
+
+func (s PortState) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateClosed:
+		return "closed"
+	case StateFiltered:
+		return "filtered"
+	default:
+		return "unknown"
+	}
+}
+
+// Container for scan results from workers
+type ScanResult struct {
+	Host      string
+	Port      uint64
+	State     PortState
+	RTT       time.Duration
+	Err       error
+	Timestamp time.Time
+
+	// Service, Banner and TLSInfo are populated by identifyService when
+	// -sV is passed; they're left zero otherwise.
+	Service string
+	Banner  string
+	TLSInfo *TLSInfo
+
+	// ProxyWorking is populated by checkSocks5 when -mode socks5 is
+	// passed; nil otherwise.
+	ProxyWorking *bool
+}
+
+// ConnectScanner scans ports the simple way: dial each one over TCP and
+// see whether the connection succeeds. It can't distinguish "closed" from
+// "filtered" since net.Dial just blocks (or errors) either way.
+//
+// Workers, DialTimeout and Retries default to sane values (see
+// NewConnectScanner) when left zero. RateLimit, if non-zero, caps probes
+// to that many per second via a token bucket.
+type ConnectScanner struct {
+	Workers     int
+	DialTimeout time.Duration
+	Retries     int
+	RateLimit   int
+}
+
+// NewConnectScanner returns a ConnectScanner configured with the
+// package's historical defaults.
+func NewConnectScanner() *ConnectScanner {
+	return &ConnectScanner{
+		Workers:     MAX_WORKERS,
+		DialTimeout: defaultDialTimeout,
+	}
+}
+
+func (c *ConnectScanner) workers() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return MAX_WORKERS
+}
+
+func (c *ConnectScanner) dialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout
+	}
+	return defaultDialTimeout
+}
+
+// ScanPorts runs the scan with a worker pool, fanning targets out over
+// jobpipe and streaming results back on the returned channel as they
+// arrive rather than buffering the whole scan in memory. Workers exit
+// once jobpipe is closed and ctx is respected between jobs and during
+// dials, so callers can cancel a scan early instead of waiting it out.
+func (c *ConnectScanner) ScanPorts(ctx context.Context, gen TargetGenerator) <-chan *ScanResult {
+	jobpipe := make(chan Target, c.workers())
+	respipe := make(chan *ScanResult, c.workers())
+
+	var bucket *tokenBucket
+	if c.RateLimit > 0 {
+		bucket = newTokenBucket(c.RateLimit)
+	}
+
+	// Start workers
+	var wg sync.WaitGroup
+	for worker := 0; worker < c.workers(); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.scanWorker(ctx, jobpipe, respipe, bucket)
+		}()
+	}
+
+	// Feed workers from the generator lazily instead of materializing
+	// every target up front. The send must also select on ctx.Done():
+	// once ctx is cancelled the workers stop draining jobpipe, and
+	// without this select jobpipe <- t would block forever.
+	go func() {
+		defer close(jobpipe)
+		for t := range gen.Targets(ctx) {
+			select {
+			case jobpipe <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		if bucket != nil {
+			bucket.close()
+		}
+		close(respipe)
+	}()
+
+	return respipe
+}
+
+// Worker function; pull from job queue until it's closed (or ctx is
+// cancelled) and return results on the result queue.
+func (c *ConnectScanner) scanWorker(ctx context.Context, jobpipe <-chan Target, respipe chan<- *ScanResult, bucket *tokenBucket) {
+	for {
+		select {
+		case t, ok := <-jobpipe:
+			if !ok {
+				return
+			}
+			respipe <- c.scanPort(ctx, t, bucket)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scanPort tries to connect to target.Host:target.Port over TCP, retrying
+// up to c.Retries times on error (e.g. a transient refusal on a busy
+// host) before giving up and reporting closed. Every attempt, including
+// retries, takes a token from bucket (if non-nil) first, so -retries
+// can't let a scan exceed -rate.
+func (c *ConnectScanner) scanPort(ctx context.Context, t Target, bucket *tokenBucket) *ScanResult {
+	dialer := net.Dialer{Timeout: c.dialTimeout()}
+	addr := fmt.Sprintf("%v:%v", t.Host, t.Port)
+
+	var lastErr error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if bucket != nil {
+			if err := bucket.take(ctx); err != nil {
+				lastErr = err
+				break
+			}
+		}
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		rtt := time.Since(start)
+		if err == nil {
+			conn.Close()
+			return &ScanResult{Host: t.Host, Port: t.Port, State: StateOpen, RTT: rtt, Timestamp: time.Now()}
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return &ScanResult{Host: t.Host, Port: t.Port, State: StateClosed, Err: lastErr, Timestamp: time.Now()}
+}
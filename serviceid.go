@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// How long to wait for a banner once connected, and for the TLS handshake.
+const probeReadTimeout = 2 * time.Second
+
+// TLSInfo is what we learn about a port from a TLS handshake without
+// actually validating the certificate chain - just enough to fingerprint
+// the service.
+type TLSInfo struct {
+	CommonName string
+	SANs       []string
+	ALPN       string
+}
+
+// Probe describes how to fingerprint a service on a given port: what (if
+// anything) to write after connecting, and the regex the banner should
+// match to call it Service.
+type Probe struct {
+	Port    uint64 `json:"port" yaml:"port"`
+	Payload string `json:"payload" yaml:"payload"`
+	Pattern string `json:"pattern" yaml:"pattern"`
+	Service string `json:"service" yaml:"service"`
+}
+
+// compiledProbe is a Probe with its pattern pre-compiled, since we match
+// it against every open port of that number.
+type compiledProbe struct {
+	Probe
+	re *regexp.Regexp
+}
+
+// defaultProbes is the built-in probe table, covering the handful of
+// protocols common enough to fingerprint without an external database.
+func defaultProbes() []Probe {
+	return []Probe{
+		{Port: 21, Pattern: `^220`, Service: "ftp"},
+		{Port: 22, Pattern: `^SSH-`, Service: "ssh"},
+		{Port: 25, Pattern: `^220`, Service: "smtp"},
+		{Port: 80, Payload: "GET / HTTP/1.0\r\n\r\n", Pattern: `^HTTP/`, Service: "http"},
+		{Port: 110, Pattern: `^\+OK`, Service: "pop3"},
+		{Port: 143, Pattern: `^\* OK`, Service: "imap"},
+		{Port: 8080, Payload: "GET / HTTP/1.0\r\n\r\n", Pattern: `^HTTP/`, Service: "http"},
+	}
+}
+
+// commonTLSPorts lists ports worth an opportunistic TLS handshake in
+// addition to (or instead of) a plaintext banner grab.
+var commonTLSPorts = map[uint64]bool{
+	443:  true,
+	465:  true,
+	636:  true,
+	993:  true,
+	995:  true,
+	8443: true,
+}
+
+// loadProbes reads a probe table from a YAML or JSON file, selected by
+// extension, for users who want to override or extend defaultProbes.
+func loadProbes(path string) ([]Probe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var probes []Probe
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &probes)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &probes)
+	default:
+		return nil, fmt.Errorf("unrecognized probe file extension %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return probes, nil
+}
+
+// compileProbes indexes probes by port and compiles each pattern once,
+// so identifyService doesn't re-compile a regex per port scanned.
+func compileProbes(probes []Probe) (map[uint64][]compiledProbe, error) {
+	byPort := make(map[uint64][]compiledProbe)
+	for _, p := range probes {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("probe %q: %w", p.Service, err)
+		}
+		byPort[p.Port] = append(byPort[p.Port], compiledProbe{Probe: p, re: re})
+	}
+	return byPort, nil
+}
+
+// identifyService connects to host:port, sends the configured probe
+// payload (if any), and reads back a banner to match against known
+// service signatures. On common TLS ports it also attempts a handshake
+// to pull the certificate CN/SANs and negotiated ALPN protocol.
+func identifyService(ctx context.Context, host string, port uint64, probes map[uint64][]compiledProbe) (service, banner string, info *TLSInfo) {
+	if commonTLSPorts[port] {
+		info = probeTLS(ctx, host, port)
+		if info != nil && info.ALPN != "" {
+			service = info.ALPN
+		}
+	}
+
+	conn, err := (&net.Dialer{Timeout: probeReadTimeout}).DialContext(ctx, "tcp", fmt.Sprintf("%v:%v", host, port))
+	if err != nil {
+		return service, banner, info
+	}
+	defer conn.Close()
+
+	for _, p := range probes[port] {
+		if p.Payload != "" {
+			conn.SetWriteDeadline(time.Now().Add(probeReadTimeout))
+			if _, err := conn.Write([]byte(p.Payload)); err != nil {
+				continue
+			}
+		}
+		conn.SetReadDeadline(time.Now().Add(probeReadTimeout))
+		buf := make([]byte, 512)
+		n, _ := conn.Read(buf)
+		banner = string(bytes.TrimRight(buf[:n], "\x00"))
+		if p.re.MatchString(banner) {
+			return p.Service, banner, info
+		}
+	}
+	return service, banner, info
+}
+
+// probeTLS attempts a TLS handshake on host:port and extracts just
+// enough of the certificate/ALPN to fingerprint the service. It never
+// validates the chain - we're identifying a service, not trusting it.
+func probeTLS(ctx context.Context, host string, port uint64) *TLSInfo {
+	dialer := &net.Dialer{Timeout: probeReadTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", fmt.Sprintf("%v:%v", host, port), &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return &TLSInfo{ALPN: state.NegotiatedProtocol}
+	}
+	cert := state.PeerCertificates[0]
+	return &TLSInfo{
+		CommonName: cert.Subject.CommonName,
+		SANs:       cert.DNSNames,
+		ALPN:       state.NegotiatedProtocol,
+	}
+}
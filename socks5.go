@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// How long to wait for the SOCKS5 greeting/CONNECT replies.
+const socks5HandshakeTimeout = 2 * time.Second
+
+// checkSocks5 is a post-connect plugin for -mode socks5: given an open
+// port, it sends a SOCKS5 greeting (05 01 00) and checks for the
+// server's 05 00 reply. If checkURL is set, it goes a step further and
+// issues a CONNECT to that target to confirm the proxy actually forwards
+// traffic rather than just speaking the handshake.
+func checkSocks5(ctx context.Context, host string, port uint64, checkURL string) (bool, error) {
+	conn, err := (&net.Dialer{Timeout: socks5HandshakeTimeout}).DialContext(ctx, "tcp", fmt.Sprintf("%v:%v", host, port))
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return false, err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false, err
+	}
+	if reply[0] != 0x05 || reply[1] != 0x00 {
+		return false, nil
+	}
+	if checkURL == "" {
+		return true, nil
+	}
+	return socks5Connect(conn, checkURL)
+}
+
+// socks5Connect issues a SOCKS5 CONNECT request for target over an
+// already-greeted connection and reports whether the proxy accepted it.
+func socks5Connect(conn net.Conn, target string) (bool, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false, err
+	}
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+
+	req := []byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	req = append(req, []byte(host)...)
+	var portBytes [2]byte
+	portNum, err := parsePort(port)
+	if err != nil {
+		return false, err
+	}
+	portBytes[0] = byte(portNum >> 8)
+	portBytes[1] = byte(portNum)
+	req = append(req, portBytes[:]...)
+
+	conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	if _, err := conn.Write(req); err != nil {
+		return false, err
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return false, err
+	}
+	return reply[1] == 0x00, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	pr, err := parseRange(s)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(pr.Start), nil
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// How long to wait for a SYN-ACK/RST before giving up on a probe and
+// calling the port filtered.
+const synReplyTimeout = 2 * time.Second
+
+// captureReadTimeout bounds how long a single pcap read blocks, so
+// correlateReplies can periodically check whether it's been told to stop
+// instead of blocking forever on a quiet link.
+const captureReadTimeout = 500 * time.Millisecond
+
+// SynScanner performs a half-open TCP scan: it sends a bare SYN per port
+// and classifies the result from the reply (or lack of one) instead of
+// completing the handshake. This needs raw-socket access, so unlike
+// ConnectScanner it captures on a single shared pcap handle and
+// correlates replies back to in-flight probes by SYN sequence number
+// (a reply's ack number is always seq+1, so inflightProbes keys on seq).
+type SynScanner struct {
+	// Iface is the network interface to send/capture on, e.g. "eth0".
+	Iface string
+}
+
+// probe tracks an in-flight SYN so the capture loop can match a reply to
+// it and compute RTT once one arrives.
+type probe struct {
+	host   string
+	port   uint64
+	seq    uint32
+	sentAt time.Time
+}
+
+// inflightProbes is a map of sequence number to probe, guarded by a mutex
+// since the sender, the capture correlator, and per-probe timeout
+// goroutines all touch it concurrently.
+type inflightProbes struct {
+	mu sync.Mutex
+	m  map[uint32]*probe
+}
+
+func (p *inflightProbes) add(pr *probe) {
+	p.mu.Lock()
+	p.m[pr.seq] = pr
+	p.mu.Unlock()
+}
+
+// take removes and returns the probe for seq, if still waiting.
+func (p *inflightProbes) take(seq uint32) (*probe, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr, ok := p.m[seq]
+	if ok {
+		delete(p.m, seq)
+	}
+	return pr, ok
+}
+
+// ScanPorts sends a SYN per target yielded by gen and streams back a
+// classification of open/closed/filtered, based on the reply gopacket
+// observes on the shared capture handle, as each one resolves. One
+// goroutine crafts and writes SYNs, one reads the handle and correlates
+// replies, and one timeout goroutine per probe reports filtered for
+// anything that never gets an answer.
+func (s *SynScanner) ScanPorts(ctx context.Context, gen TargetGenerator) <-chan *ScanResult {
+	respipe := make(chan *ScanResult, 64)
+
+	// A finite read timeout (rather than pcap.BlockForever) lets
+	// correlateReplies poll its stop channel between reads; a blocked
+	// BlockForever read isn't reliably interrupted by handle.Close()
+	// from another goroutine, which would hang ScanPorts forever once
+	// the sender is done.
+	handle, err := pcap.OpenLive(s.Iface, 65535, true, captureReadTimeout)
+	if err != nil {
+		// Raw sockets usually need root; report the failure once rather
+		// than per-target since we can't even open the capture handle.
+		go func() {
+			respipe <- &ScanResult{State: StateFiltered, Err: err, Timestamp: time.Now()}
+			close(respipe)
+		}()
+		return respipe
+	}
+
+	srcMAC, srcIP, err := ifaceAddrs(s.Iface)
+	if err != nil {
+		handle.Close()
+		go func() {
+			respipe <- &ScanResult{State: StateFiltered, Err: err, Timestamp: time.Now()}
+			close(respipe)
+		}()
+		return respipe
+	}
+
+	srcPort := uint16(1024 + rand.Intn(64511))
+	if err := handle.SetBPFFilter(fmt.Sprintf("tcp and dst port %d", srcPort)); err != nil {
+		handle.Close()
+		go func() {
+			respipe <- &ScanResult{State: StateFiltered, Err: err, Timestamp: time.Now()}
+			close(respipe)
+		}()
+		return respipe
+	}
+
+	inflight := &inflightProbes{m: make(map[uint32]*probe)}
+	sender := &synSender{iface: s.Iface, handle: handle, srcMAC: srcMAC, srcIP: srcIP, srcPort: srcPort}
+	var wg sync.WaitGroup
+
+	// stop tells correlateReplies to exit; it's signalled instead of
+	// relying on handle.Close() to interrupt an in-progress read, which
+	// isn't guaranteed across platforms/timeouts. done lets the sender
+	// goroutine block until correlateReplies has actually returned, so
+	// it can't close respipe out from under a still-running send.
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		correlateReplies(handle, inflight, respipe, stop)
+		close(done)
+	}()
+
+	go func() {
+		for t := range gen.Targets(ctx) {
+			seq := rand.Uint32()
+			pr := &probe{host: t.Host, port: t.Port, seq: seq, sentAt: time.Now()}
+			inflight.add(pr)
+			wg.Add(1)
+			if err := sender.send(t.Host, uint16(t.Port), seq); err != nil {
+				inflight.take(seq)
+				respipe <- &ScanResult{Host: t.Host, Port: t.Port, State: StateFiltered, Err: err, Timestamp: time.Now()}
+				wg.Done()
+				continue
+			}
+			go timeoutProbe(pr, inflight, respipe, &wg)
+		}
+		wg.Wait()
+		close(stop)
+		<-done
+		handle.Close()
+		close(respipe)
+	}()
+
+	return respipe
+}
+
+// ifaceAddrs resolves the hardware address and first IPv4 address bound
+// to the named interface, so sendSyn can build a frame with a real
+// source address instead of an unroutable 0.0.0.0.
+func ifaceAddrs(name string) (net.HardwareAddr, net.IP, error) {
+	ifc, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			return ifc.HardwareAddr, ip4, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no IPv4 address on interface %s", name)
+}
+
+// synSender crafts and writes SYNs on behalf of a single SynScanner.ScanPorts
+// call, caching the destination MAC per host so a CIDR scan doesn't ARP
+// once per port.
+type synSender struct {
+	iface   string
+	handle  *pcap.Handle
+	srcMAC  net.HardwareAddr
+	srcIP   net.IP
+	srcPort uint16
+
+	mu       sync.Mutex
+	macCache map[string]net.HardwareAddr
+}
+
+// send crafts and writes a single TCP SYN, framed in Ethernet+IPv4, with
+// a randomized sequence number, targeting host:port from s.srcPort.
+func (s *synSender) send(host string, dstPort uint16, seq uint32) error {
+	dstIP := net.ParseIP(host).To4()
+	if dstIP == nil {
+		return fmt.Errorf("synSender: not an IPv4 address: %s", host)
+	}
+	dstMAC, err := s.dstMAC(dstIP)
+	if err != nil {
+		return err
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       s.srcMAC,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		SrcIP:    s.srcIP,
+		DstIP:    dstIP,
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(s.srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		Seq:     seq,
+		SYN:     true,
+		Window:  14600,
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp); err != nil {
+		return err
+	}
+	return s.handle.WritePacketData(buf.Bytes())
+}
+
+// dstMAC returns the hardware address for dstIP, ARPing for it on first
+// use and caching the result for subsequent ports on the same host.
+func (s *synSender) dstMAC(dstIP net.IP) (net.HardwareAddr, error) {
+	key := dstIP.String()
+
+	s.mu.Lock()
+	if s.macCache == nil {
+		s.macCache = make(map[string]net.HardwareAddr)
+	}
+	if mac, ok := s.macCache[key]; ok {
+		s.mu.Unlock()
+		return mac, nil
+	}
+	s.mu.Unlock()
+
+	mac, err := resolveMAC(s.iface, s.srcMAC, s.srcIP, dstIP)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.macCache[key] = mac
+	s.mu.Unlock()
+	return mac, nil
+}
+
+// resolveMAC ARPs for dstIP's hardware address. It opens a short-lived
+// pcap handle of its own rather than sharing the main capture handle, so
+// it doesn't have to fight over that handle's "tcp and dst port" filter
+// or steal packets off correlateReplies' stream.
+func resolveMAC(iface string, srcMAC net.HardwareAddr, srcIP, dstIP net.IP) (net.HardwareAddr, error) {
+	handle, err := pcap.OpenLive(iface, 65535, true, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+	if err := handle.SetBPFFilter(fmt.Sprintf("arp and src host %s", dstIP)); err != nil {
+		return nil, err
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   srcMAC,
+		SourceProtAddress: srcIP,
+		DstHwAddress:      net.HardwareAddr{0, 0, 0, 0, 0, 0},
+		DstProtAddress:    dstIP,
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, arp); err != nil {
+		return nil, err
+	}
+	if err := handle.WritePacketData(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	packets := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
+	select {
+	case pkt := <-packets:
+		arpLayer := pkt.Layer(layers.LayerTypeARP)
+		if arpLayer == nil {
+			return nil, fmt.Errorf("resolveMAC: non-ARP packet matched filter")
+		}
+		reply := arpLayer.(*layers.ARP)
+		return net.HardwareAddr(reply.SourceHwAddress), nil
+	case <-time.After(2 * time.Second):
+		return nil, fmt.Errorf("arp resolution for %s timed out", dstIP)
+	}
+}
+
+// correlateReplies reads captured packets and matches SYN-ACK/RST replies
+// back to an in-flight probe by ack number, emitting a ScanResult and
+// removing the probe so timeoutProbe won't double-report it. It reads
+// directly off handle rather than via gopacket.PacketSource's channel so
+// it can poll stop between reads instead of blocking until handle.Close()
+// (which a finite captureReadTimeout makes wait at most that long).
+func correlateReplies(handle *pcap.Handle, inflight *inflightProbes, respipe chan<- *ScanResult, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		data, _, err := handle.ReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		}
+		if err == io.EOF {
+			// Handle closed out from under us; nothing left to read.
+			return
+		}
+		if err != nil {
+			// A transient pcap error shouldn't permanently stop
+			// correlation for the rest of the scan; retry like
+			// gopacket.PacketSource does for its own non-fatal errors,
+			// with a short sleep so a persistent error can't spin the CPU.
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+
+		pkt := gopacket.NewPacket(data, handle.LinkType(), gopacket.NoCopy)
+		tcpLayer := pkt.Layer(layers.LayerTypeTCP)
+		if tcpLayer == nil {
+			continue
+		}
+		tcp := tcpLayer.(*layers.TCP)
+		var state PortState
+		switch {
+		case tcp.SYN && tcp.ACK:
+			state = StateOpen
+		case tcp.RST:
+			state = StateClosed
+		default:
+			// Not a reply to a SYN we sent; leave the probe in flight
+			// for a later packet (or timeoutProbe) to handle.
+			continue
+		}
+		pr, ok := inflight.take(tcp.Ack - 1)
+		if !ok {
+			continue
+		}
+		respipe <- &ScanResult{Host: pr.host, Port: pr.port, State: state, RTT: time.Since(pr.sentAt), Timestamp: time.Now()}
+	}
+}
+
+// timeoutProbe reports a port filtered if nothing correlated its SYN
+// within synReplyTimeout.
+func timeoutProbe(pr *probe, inflight *inflightProbes, respipe chan<- *ScanResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	time.Sleep(synReplyTimeout)
+	if _, stillWaiting := inflight.take(pr.seq); stillWaiting {
+		respipe <- &ScanResult{Host: pr.host, Port: pr.port, State: StateFiltered, Timestamp: time.Now()}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+)
+
+// Target is a single (host, port) pair to probe.
+type Target struct {
+	Host string
+	Port uint64
+}
+
+// TargetGenerator lazily produces the targets a scan should cover. It's an
+// interface rather than a slice so a CIDR block or a hosts file can be
+// expanded host-by-host instead of materializing cidr_size * num_ports
+// targets up front.
+type TargetGenerator interface {
+	// Targets streams every target to scan on the returned channel,
+	// closing it once exhausted or once ctx is done.
+	Targets(ctx context.Context) <-chan Target
+}
+
+// multiTargetGenerator expands a list of host specs (plain hosts or CIDR
+// blocks) against a list of port ranges.
+type multiTargetGenerator struct {
+	hostSpecs []string
+	ranges    []*PortRange
+}
+
+func newTargetGenerator(hostSpecs []string, ranges []*PortRange) *multiTargetGenerator {
+	return &multiTargetGenerator{hostSpecs: hostSpecs, ranges: ranges}
+}
+
+func (g *multiTargetGenerator) Targets(ctx context.Context) <-chan Target {
+	out := make(chan Target)
+	go func() {
+		defer close(out)
+		for _, spec := range g.hostSpecs {
+			for host := range expandHostSpec(ctx, spec) {
+				for _, pr := range g.ranges {
+					for port := pr.Start; port < pr.End+1; port++ {
+						select {
+						case out <- Target{Host: host, Port: port}:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// expandHostSpec yields the individual hosts named by spec: every address
+// in a CIDR block, or just spec itself if it isn't one. CIDR blocks are
+// walked lazily, one IP at a time, so a /8 doesn't get materialized as a
+// giant slice.
+func expandHostSpec(ctx context.Context, spec string) <-chan string {
+	out := make(chan string)
+	if !strings.Contains(spec, "/") {
+		go func() {
+			defer close(out)
+			select {
+			case out <- spec:
+			case <-ctx.Done():
+			}
+		}()
+		return out
+	}
+
+	ip, ipnet, err := net.ParseCIDR(spec)
+	if err != nil {
+		// Not a valid CIDR either; treat it as a literal (probably
+		// unresolvable) host and let the scanner report the dial error.
+		go func() {
+			defer close(out)
+			select {
+			case out <- spec:
+			case <-ctx.Done():
+			}
+		}()
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		for cur := ip.Mask(ipnet.Mask); ipnet.Contains(cur); incIP(cur) {
+			select {
+			case out <- cur.String():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// incIP increments an IP address in place, carrying from the last octet.
+func incIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
+
+// parseHostSpecs splits a comma-separated list of hosts/CIDR blocks.
+func parseHostSpecs(arg string) []string {
+	if arg == "" {
+		return nil
+	}
+	parts := strings.Split(arg, ",")
+	specs := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			specs = append(specs, p)
+		}
+	}
+	return specs
+}
+
+// readLines reads non-blank, non-comment lines from a file, one target
+// spec or port range per line, as used by -hosts-file and -ports-file.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}